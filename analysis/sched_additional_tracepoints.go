@@ -0,0 +1,188 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package sched
+
+import "github.com/google/schedviz/tracedata/trace"
+
+// LoadSchedWaking loads a sched::sched_waking event, the earlier of the two
+// tracepoints -- alongside sched_wakeup -- that modern kernels may emit when
+// a sleeping task becomes runnable. Unlike sched_wakeup, sched_waking fires
+// on the waker's CPU rather than the wakee's target CPU, and a single
+// logical wakeup can produce both a sched_waking and a later sched_wakeup.
+// Because either tracepoint may be redundant with, or missing relative to,
+// the other, LoadSchedWaking's transitions default to dropping on any
+// conflict, exactly as LoadSchedWakeup's do. Use PreferWakingOverWakeup to
+// make sched_waking authoritative instead, when both tracepoints are
+// present and sched_waking is believed to be the more reliable of the pair.
+func LoadSchedWaking(ev *trace.Event, ttsb *ThreadTransitionSetBuilder) error {
+	return loadSchedWaking(ev, ttsb, true /* =relaxed */)
+}
+
+// loadSchedWakingAuthoritative loads a sched::sched_waking event identically
+// to LoadSchedWaking, except that its CPU and state assertions are left
+// strict rather than relaxed to drop on conflict, so that a disagreeing
+// sched_wakeup is dropped instead. Used by PreferWakingOverWakeup.
+func loadSchedWakingAuthoritative(ev *trace.Event, ttsb *ThreadTransitionSetBuilder) error {
+	return loadSchedWaking(ev, ttsb, false /* =relaxed */)
+}
+
+// loadSchedWaking implements LoadSchedWaking and loadSchedWakingAuthoritative,
+// which differ only in whether the resulting transition's CPU and state
+// assertions are relaxed to drop on conflict.
+func loadSchedWaking(ev *trace.Event, ttsb *ThreadTransitionSetBuilder, relaxed bool) error {
+	pid, ok := ev.NumberProperties["pid"]
+	if !ok {
+		return MissingFieldError("pid", ev)
+	}
+	comm := ev.TextProperties["comm"]
+	prio, ok := ev.NumberProperties["prio"]
+	priority := Priority(prio)
+	if !ok {
+		priority = UnknownPriority
+	}
+	targetCPU, ok := ev.NumberProperties["target_cpu"]
+	if !ok {
+		return MissingFieldError("target_cpu", ev)
+	}
+	transition := ttsb.WithTransition(ev.Index, ev.Timestamp, PID(pid)).
+		WithPrevCommand(comm).
+		WithNextCommand(comm).
+		WithPrevPriority(priority).
+		WithNextPriority(priority).
+		WithPrevCPU(CPUID(targetCPU)).
+		WithNextCPU(CPUID(targetCPU)).
+		WithNextState(WaitingState)
+	if relaxed {
+		transition.
+			OnBackwardsCPUConflict(Drop).
+			OnForwardsCPUConflict(Drop).
+			OnForwardsStateConflict(Drop)
+	}
+	return nil
+}
+
+// PreferWakingOverWakeup returns a copy of loaders in which sched_waking, if
+// present, is made authoritative: its CPU and state assertions are no
+// longer relaxed to drop on conflict, so a disagreeing sched_wakeup
+// transition is dropped instead. Use this when both tracepoints are present
+// and sched_waking -- which fires earlier, on the waker's CPU -- is known to
+// be the more reliable of the pair on the kernel that produced the trace.
+func PreferWakingOverWakeup(loaders map[string]func(*trace.Event, *ThreadTransitionSetBuilder) error) map[string]func(*trace.Event, *ThreadTransitionSetBuilder) error {
+	out := make(map[string]func(*trace.Event, *ThreadTransitionSetBuilder) error, len(loaders))
+	for name, loader := range loaders {
+		out[name] = loader
+	}
+	if _, ok := out["sched_waking"]; ok {
+		out["sched_waking"] = loadSchedWakingAuthoritative
+	}
+	return out
+}
+
+// LoadSchedProcessFork loads a sched::sched_process_fork event, seeding the
+// lifetime of a newly created PID so that it does not appear to begin in an
+// unknown state at whatever point its first sched_switch or sched_wakeup
+// happens to occur.
+func LoadSchedProcessFork(ev *trace.Event, ttsb *ThreadTransitionSetBuilder) error {
+	childPID, ok := ev.NumberProperties["child_pid"]
+	if !ok {
+		return MissingFieldError("child_pid", ev)
+	}
+	childComm := ev.TextProperties["child_comm"]
+	// A forked task isn't runnable until a later sched_wakeup or
+	// sched_switch reports it; record only that it now exists, in Sleeping
+	// state, with no CPU yet assigned.
+	ttsb.WithTransition(ev.Index, ev.Timestamp, PID(childPID)).
+		WithPrevCommand(childComm).
+		WithNextCommand(childComm).
+		WithNextState(SleepingState)
+	return nil
+}
+
+// LoadSchedProcessExit loads a sched::sched_process_exit event, terminating
+// a PID's lifetime so that short-lived tasks do not leave a spurious
+// "unknown state" span between their last reported activity and the end of
+// the trace.
+func LoadSchedProcessExit(ev *trace.Event, ttsb *ThreadTransitionSetBuilder) error {
+	pid, ok := ev.NumberProperties["pid"]
+	if !ok {
+		return MissingFieldError("pid", ev)
+	}
+	comm := ev.TextProperties["comm"]
+	prio, ok := ev.NumberProperties["prio"]
+	priority := Priority(prio)
+	if !ok {
+		priority = UnknownPriority
+	}
+	ttsb.WithTransition(ev.Index, ev.Timestamp, PID(pid)).
+		WithPrevCommand(comm).
+		WithNextCommand(comm).
+		WithPrevPriority(priority).
+		WithNextPriority(priority).
+		WithNextState(SleepingState)
+	return nil
+}
+
+// RuntimeHint records a task's cumulative on-CPU runtime as of a single
+// sched::sched_stat_runtime event, for cross-checking against the run
+// intervals a Collection infers from sched_switch.
+type RuntimeHint struct {
+	PID       PID
+	Timestamp trace.Timestamp
+	// RuntimeNS is the cumulative nanoseconds pid has spent running, as
+	// accounted by the scheduler as of Timestamp.
+	RuntimeNS int64
+}
+
+// LoadSchedStatRuntime loads a sched::sched_stat_runtime event into a
+// RuntimeHint. Unlike the other loaders in this file, sched_stat_runtime
+// describes cumulative scheduler accounting rather than a state or CPU
+// change, so it is not a threadTransition and is not part of
+// DefaultEventLoaders; instead, RuntimeHintsFromEvents runs it as a
+// parallel, read-only pass over a trace's events.
+func LoadSchedStatRuntime(ev *trace.Event) (*RuntimeHint, error) {
+	pid, ok := ev.NumberProperties["pid"]
+	if !ok {
+		return nil, MissingFieldError("pid", ev)
+	}
+	runtime, ok := ev.NumberProperties["runtime"]
+	if !ok {
+		return nil, MissingFieldError("runtime", ev)
+	}
+	return &RuntimeHint{
+		PID:       PID(pid),
+		Timestamp: ev.Timestamp,
+		RuntimeNS: runtime,
+	}, nil
+}
+
+// RuntimeHintsFromEvents returns the RuntimeHints produced by every
+// sched_stat_runtime event among events, in trace order, for cross-checking
+// against a Collection's inferred run intervals.
+func RuntimeHintsFromEvents(events []*trace.Event) ([]*RuntimeHint, error) {
+	var hints []*RuntimeHint
+	for _, ev := range events {
+		if ev.Clipped || ev.Name != "sched_stat_runtime" {
+			continue
+		}
+		hint, err := LoadSchedStatRuntime(ev)
+		if err != nil {
+			return nil, err
+		}
+		hints = append(hints, hint)
+	}
+	return hints, nil
+}
@@ -0,0 +1,127 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package sched
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/schedviz/tracedata/trace"
+)
+
+// fnPointer returns fn's entry address, for asserting that two
+// func(*trace.Event, *ThreadTransitionSetBuilder) error values are (or
+// aren't) the same underlying function -- these func types aren't otherwise
+// comparable.
+func fnPointer(fn func(*trace.Event, *ThreadTransitionSetBuilder) error) uintptr {
+	return reflect.ValueOf(fn).Pointer()
+}
+
+func TestLoadSchedWakingMissingFields(t *testing.T) {
+	tests := []struct {
+		name string
+		ev   *trace.Event
+	}{
+		{"missing pid", &trace.Event{NumberProperties: map[string]int64{"target_cpu": 1}}},
+		{"missing target_cpu", &trace.Event{NumberProperties: map[string]int64{"pid": 1}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := LoadSchedWaking(test.ev, nil); err == nil {
+				t.Errorf("LoadSchedWaking(%+v, nil) = nil, want error", test.ev)
+			}
+		})
+	}
+}
+
+func TestLoadSchedProcessForkMissingFields(t *testing.T) {
+	ev := &trace.Event{NumberProperties: map[string]int64{}}
+	if err := LoadSchedProcessFork(ev, nil); err == nil {
+		t.Errorf("LoadSchedProcessFork(%+v, nil) = nil, want error", ev)
+	}
+}
+
+func TestLoadSchedProcessExitMissingFields(t *testing.T) {
+	ev := &trace.Event{NumberProperties: map[string]int64{}}
+	if err := LoadSchedProcessExit(ev, nil); err == nil {
+		t.Errorf("LoadSchedProcessExit(%+v, nil) = nil, want error", ev)
+	}
+}
+
+func TestPreferWakingOverWakeupReplacesOnlyWaking(t *testing.T) {
+	sentinel := func(ev *trace.Event, ttsb *ThreadTransitionSetBuilder) error { return nil }
+	loaders := map[string]func(*trace.Event, *ThreadTransitionSetBuilder) error{
+		"sched_waking": LoadSchedWaking,
+		"sched_wakeup": sentinel,
+	}
+	out := PreferWakingOverWakeup(loaders)
+	if len(out) != len(loaders) {
+		t.Fatalf("PreferWakingOverWakeup returned %d loaders, want %d", len(out), len(loaders))
+	}
+	if _, ok := out["sched_waking"]; !ok {
+		t.Fatalf("PreferWakingOverWakeup dropped sched_waking")
+	}
+	origWaking := loaders["sched_waking"]
+	if fnPointer(out["sched_waking"]) == fnPointer(origWaking) {
+		t.Errorf("PreferWakingOverWakeup did not replace sched_waking with an authoritative loader")
+	}
+	// Unrelated tracepoints must be passed through untouched.
+	if fnPointer(out["sched_wakeup"]) != fnPointer(sentinel) {
+		t.Errorf("PreferWakingOverWakeup modified sched_wakeup, want it unchanged")
+	}
+}
+
+func TestLoadSchedStatRuntime(t *testing.T) {
+	ev := &trace.Event{
+		Timestamp:        42,
+		NumberProperties: map[string]int64{"pid": 7, "runtime": 1000},
+	}
+	hint, err := LoadSchedStatRuntime(ev)
+	if err != nil {
+		t.Fatalf("LoadSchedStatRuntime(%+v) returned error: %v", ev, err)
+	}
+	want := &RuntimeHint{PID: 7, Timestamp: 42, RuntimeNS: 1000}
+	if *hint != *want {
+		t.Errorf("LoadSchedStatRuntime(%+v) = %+v, want %+v", ev, hint, want)
+	}
+}
+
+func TestLoadSchedStatRuntimeMissingFields(t *testing.T) {
+	ev := &trace.Event{NumberProperties: map[string]int64{"pid": 7}}
+	if _, err := LoadSchedStatRuntime(ev); err == nil {
+		t.Errorf("LoadSchedStatRuntime(%+v) = nil error, want error", ev)
+	}
+}
+
+func TestRuntimeHintsFromEventsSkipsClippedAndOtherEvents(t *testing.T) {
+	events := []*trace.Event{
+		{Name: "sched_stat_runtime", NumberProperties: map[string]int64{"pid": 1, "runtime": 10}},
+		{Name: "sched_stat_runtime", Clipped: true, NumberProperties: map[string]int64{"pid": 2, "runtime": 20}},
+		{Name: "sched_switch", NumberProperties: map[string]int64{"pid": 3}},
+		{Name: "sched_stat_runtime", NumberProperties: map[string]int64{"pid": 4, "runtime": 40}},
+	}
+	hints, err := RuntimeHintsFromEvents(events)
+	if err != nil {
+		t.Fatalf("RuntimeHintsFromEvents returned error: %v", err)
+	}
+	if len(hints) != 2 {
+		t.Fatalf("RuntimeHintsFromEvents returned %d hints, want 2", len(hints))
+	}
+	if hints[0].PID != 1 || hints[1].PID != 4 {
+		t.Errorf("RuntimeHintsFromEvents = %+v, want hints for PIDs 1 and 4 only", hints)
+	}
+}
@@ -236,6 +236,9 @@ func DefaultEventLoaders() map[string]func(*trace.Event, *ThreadTransitionSetBui
 		"sched_switch":       LoadSchedSwitch,
 		"sched_wakeup":       LoadSchedWakeup,
 		"sched_wakeup_new":   LoadSchedWakeup,
+		"sched_waking":       LoadSchedWaking,
+		"sched_process_fork": LoadSchedProcessFork,
+		"sched_process_exit": LoadSchedProcessExit,
 	}
 }
 
@@ -243,6 +246,13 @@ func DefaultEventLoaders() map[string]func(*trace.Event, *ThreadTransitionSetBui
 // that lacks other events that could signal thread state or CPU changes.
 // Wherever a state or CPU transition is missing, a synthetic transition will
 // be inserted midway between the two adjacent known transitions.
+//
+// This is the original, stateless implementation, which relies on the
+// ThreadTransitionSetBuilder's own InsertSynthetic conflict resolution and so
+// is safe to use as a single, shared function value across any number of
+// independent collections. Callers wanting a different placement than the
+// fixed midpoint rule should use LoadSchedSwitchWithSyntheticsUsing instead,
+// which must be instantiated fresh per collection.
 func LoadSchedSwitchWithSynthetics(ev *trace.Event, ttsb *ThreadTransitionSetBuilder) error {
 	sd, err := LoadSwitchData(ev)
 	if err != nil {
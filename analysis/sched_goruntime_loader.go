@@ -0,0 +1,238 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package sched
+
+import (
+	"fmt"
+	"io"
+
+	gotrace "golang.org/x/exp/trace"
+
+	"github.com/google/schedviz/tracedata/trace"
+)
+
+// Go execution-tracer events are converted into trace.Events under these
+// synthetic names, mirroring how ftrace tracepoint names key
+// DefaultEventLoaders, so that goroutine scheduling can be driven through
+// the same loader/ThreadTransitionSetBuilder machinery as kernel traces.
+const (
+	goEventGoStart   = "go_start"
+	goEventGoStop    = "go_stop"
+	goEventGoUnblock = "go_unblock"
+	goEventGoMigrate = "go_migrate"
+)
+
+// LoadGoStart loads a go_start event, derived from the Go execution tracer's
+// GoStart, indicating that goroutine g began running on proc p.
+func LoadGoStart(ev *trace.Event, ttsb *ThreadTransitionSetBuilder) error {
+	g, ok := ev.NumberProperties["g"]
+	if !ok {
+		return MissingFieldError("g", ev)
+	}
+	p, ok := ev.NumberProperties["p"]
+	if !ok {
+		return MissingFieldError("p", ev)
+	}
+	ttsb.WithTransition(ev.Index, ev.Timestamp, PID(g)).
+		WithPrevCPU(CPUID(p)).
+		WithNextCPU(CPUID(p)).
+		WithNextState(RunningState)
+	return nil
+}
+
+// LoadGoStop loads a go_stop event, derived from the Go execution tracer's
+// GoStop or GoBlock, indicating that the running goroutine g on proc p
+// stopped or blocked and is no longer runnable.
+func LoadGoStop(ev *trace.Event, ttsb *ThreadTransitionSetBuilder) error {
+	g, ok := ev.NumberProperties["g"]
+	if !ok {
+		return MissingFieldError("g", ev)
+	}
+	p, ok := ev.NumberProperties["p"]
+	if !ok {
+		return MissingFieldError("p", ev)
+	}
+	ttsb.WithTransition(ev.Index, ev.Timestamp, PID(g)).
+		WithPrevCPU(CPUID(p)).
+		WithNextCPU(CPUID(p)).
+		WithPrevState(RunningState).
+		WithNextState(SleepingState)
+	return nil
+}
+
+// LoadGoUnblock loads a go_unblock event, derived from the Go execution
+// tracer's GoUnblock, indicating that goroutine g, previously blocked,
+// became runnable again.
+//
+// GoUnblock, like sched_wakeup, is frequently reported from a P other than
+// the one g will next run on, and can race with other scheduling events for
+// the same goroutine. As with LoadSchedWakeup, GoUnblock's CPU and state
+// assertions are therefore relaxed to drop on conflict rather than fail the
+// whole trace.
+func LoadGoUnblock(ev *trace.Event, ttsb *ThreadTransitionSetBuilder) error {
+	g, ok := ev.NumberProperties["g"]
+	if !ok {
+		return MissingFieldError("g", ev)
+	}
+	p, ok := ev.NumberProperties["p"]
+	if !ok {
+		return MissingFieldError("p", ev)
+	}
+	ttsb.WithTransition(ev.Index, ev.Timestamp, PID(g)).
+		WithPrevCPU(CPUID(p)).
+		WithNextCPU(CPUID(p)).
+		WithNextState(WaitingState).
+		OnBackwardsCPUConflict(Drop).
+		OnForwardsCPUConflict(Drop).
+		OnForwardsStateConflict(Drop)
+	return nil
+}
+
+// LoadGoMigrate loads a go_migrate event, derived from the Go execution
+// tracer's ProcSteal or GoSched, indicating that goroutine g moved from proc
+// origP to proc destP without an intervening block, analogous to a
+// sched_migrate_task.
+//
+// EventsFromGoExecutionTrace does not currently produce go_migrate events:
+// reliably telling ProcSteal/GoSched apart from an ordinary ProcStart/
+// ProcStop in golang.org/x/exp/trace's public API needs more care than this
+// package has given it so far, so LoadGoMigrate exists for callers with
+// their own adapter, but GoRuntimeEventLoaders' migrations go undetected
+// until that adapter work is done.
+func LoadGoMigrate(ev *trace.Event, ttsb *ThreadTransitionSetBuilder) error {
+	g, ok := ev.NumberProperties["g"]
+	if !ok {
+		return MissingFieldError("g", ev)
+	}
+	origP, ok := ev.NumberProperties["orig_p"]
+	if !ok {
+		return MissingFieldError("orig_p", ev)
+	}
+	destP, ok := ev.NumberProperties["dest_p"]
+	if !ok {
+		return MissingFieldError("dest_p", ev)
+	}
+	ttsb.WithTransition(ev.Index, ev.Timestamp, PID(g)).
+		WithPrevCPU(CPUID(origP)).
+		WithNextCPU(CPUID(destP))
+	return nil
+}
+
+// GoRuntimeEventLoaders is a set of event loader functions for Go
+// execution-tracer events, mapping each goroutine (G) onto a PID and each
+// logical processor (P) onto a CPUID, so that Go program scheduling
+// behavior can be visualized with the same analyses as kernel scheduling
+// traces.
+func GoRuntimeEventLoaders() map[string]func(*trace.Event, *ThreadTransitionSetBuilder) error {
+	return map[string]func(*trace.Event, *ThreadTransitionSetBuilder) error{
+		goEventGoStart:   LoadGoStart,
+		goEventGoStop:    LoadGoStop,
+		goEventGoUnblock: LoadGoUnblock,
+		goEventGoMigrate: LoadGoMigrate,
+	}
+}
+
+// EventsFromGoExecutionTrace decodes a trace produced by the Go runtime
+// execution tracer (e.g. via runtime/trace.Start, or `go test -trace`) into
+// trace.Events suitable for GoRuntimeEventLoaders. It reads the trace with
+// golang.org/x/exp/trace, the public reader for the v2 execution trace
+// format exposed by internal/trace/v2, since that package itself cannot be
+// imported outside the standard library.
+func EventsFromGoExecutionTrace(r io.Reader) ([]*trace.Event, error) {
+	tr, err := gotrace.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open Go execution trace: %w", err)
+	}
+	var events []*trace.Event
+	for {
+		ev, err := tr.ReadEvent()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Go execution trace event %d: %w", len(events), err)
+		}
+		converted, ok := goTraceEventToTraceEvent(len(events), ev)
+		if !ok {
+			continue
+		}
+		events = append(events, converted)
+	}
+	return events, nil
+}
+
+// goStateTransitionEventName returns the synthetic tracepoint name a
+// ResourceGoroutine state transition from -> to should be converted into, or
+// ok == false for transitions this package doesn't ingest.
+//
+// A GoRunning -> GoRunnable transition is preemption, not a block: the
+// goroutine is still runnable, just off-CPU, exactly the case this
+// package's sched_switch loader reports as WaitingState rather than
+// SleepingState for prev_state == 0. It is therefore folded into
+// go_unblock alongside the GoWaiting -> GoRunnable case, not go_stop.
+// GoSched is one source of this transition and, like ProcSteal, is a
+// migration candidate LoadGoMigrate doesn't yet detect -- see its doc
+// comment -- but mapping it to go_stop/SleepingState would have been wrong
+// regardless of that gap.
+func goStateTransitionEventName(from, to gotrace.GoState) (name string, ok bool) {
+	switch {
+	case to == gotrace.GoRunning:
+		return goEventGoStart, true
+	case to == gotrace.GoRunnable:
+		return goEventGoUnblock, true
+	case from == gotrace.GoRunning:
+		return goEventGoStop, true
+	default:
+		return "", false
+	}
+}
+
+// goTraceEventToTraceEvent converts a single golang.org/x/exp/trace.Event
+// into a trace.Event understood by GoRuntimeEventLoaders, or returns ok ==
+// false for event kinds this package doesn't ingest.
+//
+// Only ResourceGoroutine state transitions are converted. Event.Goroutine()
+// reports NoGoroutine for state-transition events -- the goroutine a
+// transition applies to is st.Resource.Goroutine() instead -- and
+// ResourceProc transitions (ProcStart/ProcStop/ProcSteal/GoSched) are left
+// unhandled for now; see LoadGoMigrate's doc comment for why.
+func goTraceEventToTraceEvent(index int, ev gotrace.Event) (*trace.Event, bool) {
+	if ev.Kind() != gotrace.EventStateTransition {
+		return nil, false
+	}
+	st := ev.StateTransition()
+	if st.Resource.Kind != gotrace.ResourceGoroutine {
+		return nil, false
+	}
+	g := st.Resource.Goroutine()
+	from, to := st.Goroutine()
+	name, ok := goStateTransitionEventName(from, to)
+	if !ok {
+		return nil, false
+	}
+	return &trace.Event{
+		Index:     index,
+		Name:      name,
+		CPU:       CPUID(ev.Proc()),
+		Timestamp: trace.Timestamp(ev.Time()),
+		NumberProperties: map[string]int64{
+			"g": int64(g),
+			"p": int64(ev.Proc()),
+		},
+		TextProperties: map[string]string{},
+	}, true
+}
@@ -0,0 +1,91 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package sched
+
+import (
+	"testing"
+
+	gotrace "golang.org/x/exp/trace"
+
+	"github.com/google/schedviz/tracedata/trace"
+)
+
+// goTraceEventToTraceEvent itself takes an opaque gotrace.Event, which only
+// golang.org/x/exp/trace's own Reader can construct, so these tests exercise
+// goStateTransitionEventName, the pure from/to -> name decision it delegates
+// to.
+func TestGoStateTransitionEventName(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to gotrace.GoState
+		wantName string
+		wantOK   bool
+	}{
+		{"waiting to running is go_start", gotrace.GoWaiting, gotrace.GoRunning, goEventGoStart, true},
+		{"runnable to running is go_start", gotrace.GoRunnable, gotrace.GoRunning, goEventGoStart, true},
+		{"waiting to runnable is go_unblock", gotrace.GoWaiting, gotrace.GoRunnable, goEventGoUnblock, true},
+		// Preemption: still runnable, not blocked, so this must not be
+		// reported as go_stop/SleepingState.
+		{"running to runnable is go_unblock, not go_stop", gotrace.GoRunning, gotrace.GoRunnable, goEventGoUnblock, true},
+		{"running to waiting is go_stop", gotrace.GoRunning, gotrace.GoWaiting, goEventGoStop, true},
+		{"running to not-exist is go_stop", gotrace.GoRunning, gotrace.GoNotExist, goEventGoStop, true},
+		{"waiting to waiting is ignored", gotrace.GoWaiting, gotrace.GoWaiting, "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			name, ok := goStateTransitionEventName(test.from, test.to)
+			if ok != test.wantOK || name != test.wantName {
+				t.Errorf("goStateTransitionEventName(%v, %v) = (%q, %v), want (%q, %v)", test.from, test.to, name, ok, test.wantName, test.wantOK)
+			}
+		})
+	}
+}
+
+func TestLoadGoStartMissingFields(t *testing.T) {
+	tests := []struct {
+		name string
+		ev   *trace.Event
+	}{
+		{"missing g", &trace.Event{NumberProperties: map[string]int64{"p": 1}}},
+		{"missing p", &trace.Event{NumberProperties: map[string]int64{"g": 1}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := LoadGoStart(test.ev, nil); err == nil {
+				t.Errorf("LoadGoStart(%+v, nil) = nil, want error", test.ev)
+			}
+		})
+	}
+}
+
+func TestLoadGoMigrateMissingFields(t *testing.T) {
+	tests := []struct {
+		name string
+		ev   *trace.Event
+	}{
+		{"missing g", &trace.Event{NumberProperties: map[string]int64{"orig_p": 1, "dest_p": 2}}},
+		{"missing orig_p", &trace.Event{NumberProperties: map[string]int64{"g": 1, "dest_p": 2}}},
+		{"missing dest_p", &trace.Event{NumberProperties: map[string]int64{"g": 1, "orig_p": 1}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := LoadGoMigrate(test.ev, nil); err == nil {
+				t.Errorf("LoadGoMigrate(%+v, nil) = nil, want error", test.ev)
+			}
+		})
+	}
+}
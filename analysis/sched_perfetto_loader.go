@@ -0,0 +1,171 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+// perfetto upstream ships perfetto/trace/trace.proto and
+// perfetto/trace/ftrace/ftrace_event.proto as .proto sources only -- it has
+// no go.mod and generates no Go package of its own. This file depends on
+// tracedata/perfettotrace{,/ftrace}, schedviz's own protoc-gen-go output for
+// the vendored subset of those two .proto files that this loader needs,
+// which has not been vendored or generated yet. Building with this file
+// included therefore requires running the (not yet written) `make generate`
+// step that would produce it; until then it's excluded from the default
+// build behind the schedviz_perfetto build tag below, so the rest of this
+// package -- and everything that imports it -- builds without it.
+//
+//go:build schedviz_perfetto
+
+package sched
+
+import (
+	"fmt"
+	"io"
+
+	"google.golang.org/protobuf/proto"
+
+	perfetto_ftrace "github.com/google/schedviz/tracedata/perfettotrace/ftrace"
+	perfetto_trace "github.com/google/schedviz/tracedata/perfettotrace"
+	"github.com/google/schedviz/tracedata/trace"
+)
+
+// perfettoSchedEventName returns the ftrace tracepoint name carried by fe, if
+// fe holds one of the sched events this package understands, so that it can
+// be dispatched through the same named loaders as ftrace-derived traces.
+func perfettoSchedEventName(fe *perfetto_ftrace.FtraceEvent) string {
+	switch {
+	case fe.GetSchedSwitch() != nil:
+		return "sched_switch"
+	case fe.GetSchedWakeup() != nil:
+		return "sched_wakeup"
+	case fe.GetSchedWakeupNew() != nil:
+		return "sched_wakeup_new"
+	case fe.GetSchedMigrateTask() != nil:
+		return "sched_migrate_task"
+	default:
+		return ""
+	}
+}
+
+// traceEventFromPerfettoFtraceEvent converts a Perfetto FtraceEvent carrying
+// one of the supported sched tracepoints into a trace.Event, populating the
+// same NumberProperties and TextProperties keys that LoadSchedSwitch,
+// LoadSchedWakeup, and LoadSchedMigrateTask already expect from
+// ftrace-derived events. It returns a nil event, with no error, for
+// FtraceEvents this package doesn't ingest.
+func traceEventFromPerfettoFtraceEvent(index int, cpu CPUID, fe *perfetto_ftrace.FtraceEvent) (*trace.Event, error) {
+	name := perfettoSchedEventName(fe)
+	if name == "" {
+		return nil, nil
+	}
+	ev := &trace.Event{
+		Index:            index,
+		Name:             name,
+		CPU:              cpu,
+		Timestamp:        trace.Timestamp(fe.GetTimestamp()),
+		NumberProperties: map[string]int64{},
+		TextProperties:   map[string]string{},
+	}
+	switch name {
+	case "sched_switch":
+		ss := fe.GetSchedSwitch()
+		ev.NumberProperties["next_pid"] = int64(ss.GetNextPid())
+		ev.TextProperties["next_comm"] = ss.GetNextComm()
+		ev.NumberProperties["next_prio"] = int64(ss.GetNextPrio())
+		ev.NumberProperties["prev_pid"] = int64(ss.GetPrevPid())
+		ev.TextProperties["prev_comm"] = ss.GetPrevComm()
+		ev.NumberProperties["prev_prio"] = int64(ss.GetPrevPrio())
+		ev.NumberProperties["prev_state"] = ss.GetPrevState()
+	case "sched_wakeup":
+		w := fe.GetSchedWakeup()
+		ev.NumberProperties["pid"] = int64(w.GetPid())
+		ev.TextProperties["comm"] = w.GetComm()
+		ev.NumberProperties["prio"] = int64(w.GetPrio())
+		ev.NumberProperties["target_cpu"] = int64(w.GetTargetCpu())
+	case "sched_wakeup_new":
+		w := fe.GetSchedWakeupNew()
+		ev.NumberProperties["pid"] = int64(w.GetPid())
+		ev.TextProperties["comm"] = w.GetComm()
+		ev.NumberProperties["prio"] = int64(w.GetPrio())
+		ev.NumberProperties["target_cpu"] = int64(w.GetTargetCpu())
+	case "sched_migrate_task":
+		mt := fe.GetSchedMigrateTask()
+		ev.NumberProperties["pid"] = int64(mt.GetPid())
+		ev.TextProperties["comm"] = mt.GetComm()
+		ev.NumberProperties["prio"] = int64(mt.GetPrio())
+		ev.NumberProperties["orig_cpu"] = int64(mt.GetOrigCpu())
+		ev.NumberProperties["dest_cpu"] = int64(mt.GetDestCpu())
+	}
+	return ev, nil
+}
+
+// eventsFromPerfetto decodes a serialized Perfetto Trace proto and returns
+// the trace.Events derived from its sched_switch, sched_wakeup,
+// sched_wakeup_new, and sched_migrate_task FtraceEvents, in the order they
+// were encountered.
+func eventsFromPerfetto(raw []byte) ([]*trace.Event, error) {
+	var t perfetto_trace.Trace
+	if err := proto.Unmarshal(raw, &t); err != nil {
+		return nil, fmt.Errorf("failed to parse Perfetto trace: %w", err)
+	}
+	var events []*trace.Event
+	for _, packet := range t.GetPacket() {
+		bundle := packet.GetFtraceEvents()
+		if bundle == nil {
+			continue
+		}
+		cpu := CPUID(bundle.GetCpu())
+		for _, fe := range bundle.GetEvent() {
+			ev, err := traceEventFromPerfettoFtraceEvent(len(events), cpu, fe)
+			if err != nil {
+				return nil, err
+			}
+			if ev == nil {
+				continue
+			}
+			events = append(events, ev)
+		}
+	}
+	return events, nil
+}
+
+// NewPerfettoEventLoader returns an eventLoader that consumes trace.Events
+// derived from Perfetto FtraceEvent protos (see eventsFromPerfetto) using
+// the same LoadSchedSwitch, LoadSchedWakeup, and LoadSchedMigrateTask logic
+// that ftrace-derived traces use, so a Perfetto-sourced sched_switch is
+// indistinguishable, once converted, from one parsed out of an ftrace
+// report.
+func NewPerfettoEventLoader(stringBank *stringBank) (*eventLoader, error) {
+	return newEventLoader(DefaultEventLoaders(), stringBank)
+}
+
+// CollectionFromPerfetto reads a serialized Perfetto Trace proto from r and
+// builds a Collection from the sched tracepoints it contains, so that traces
+// captured with `perfetto` -- e.g. from Android or Linux perf sessions --
+// can be analyzed without first converting them to ftrace's text format.
+func CollectionFromPerfetto(r io.Reader, collectionOptions ...Option) (*Collection, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Perfetto trace: %w", err)
+	}
+	events, err := eventsFromPerfetto(raw)
+	if err != nil {
+		return nil, err
+	}
+	rawColl, err := trace.NewCollection(events)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build trace.Collection from Perfetto trace: %w", err)
+	}
+	return NewCollection(rawColl, DefaultEventLoaders(), collectionOptions...)
+}
@@ -0,0 +1,104 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+// Mirrors sched_perfetto_loader.go's schedviz_perfetto build tag: these
+// tests depend on the same not-yet-vendored perfettotrace bindings, so they
+// build and run alongside that file once those bindings exist, and are
+// excluded from the default build until then.
+//
+//go:build schedviz_perfetto
+
+package sched
+
+import (
+	"testing"
+
+	perfetto_ftrace "github.com/google/schedviz/tracedata/perfettotrace/ftrace"
+)
+
+func TestPerfettoSchedEventName(t *testing.T) {
+	tests := []struct {
+		name string
+		fe   *perfetto_ftrace.FtraceEvent
+		want string
+	}{
+		{
+			"sched_switch",
+			&perfetto_ftrace.FtraceEvent{Event: &perfetto_ftrace.FtraceEvent_SchedSwitch{SchedSwitch: &perfetto_ftrace.SchedSwitchFtraceEvent{}}},
+			"sched_switch",
+		},
+		{
+			"sched_wakeup",
+			&perfetto_ftrace.FtraceEvent{Event: &perfetto_ftrace.FtraceEvent_SchedWakeup{SchedWakeup: &perfetto_ftrace.SchedWakeupFtraceEvent{}}},
+			"sched_wakeup",
+		},
+		{
+			"unrecognized event yields empty name",
+			&perfetto_ftrace.FtraceEvent{},
+			"",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := perfettoSchedEventName(test.fe); got != test.want {
+				t.Errorf("perfettoSchedEventName(%+v) = %q, want %q", test.fe, got, test.want)
+			}
+		})
+	}
+}
+
+func TestTraceEventFromPerfettoFtraceEventSchedSwitch(t *testing.T) {
+	fe := &perfetto_ftrace.FtraceEvent{
+		Timestamp: 1234,
+		Event: &perfetto_ftrace.FtraceEvent_SchedSwitch{
+			SchedSwitch: &perfetto_ftrace.SchedSwitchFtraceEvent{
+				NextPid:   42,
+				NextComm:  "next",
+				NextPrio:  10,
+				PrevPid:   7,
+				PrevComm:  "prev",
+				PrevPrio:  20,
+				PrevState: 1,
+			},
+		},
+	}
+	ev, err := traceEventFromPerfettoFtraceEvent(0, CPUID(3), fe)
+	if err != nil {
+		t.Fatalf("traceEventFromPerfettoFtraceEvent returned error: %v", err)
+	}
+	if ev == nil {
+		t.Fatalf("traceEventFromPerfettoFtraceEvent returned a nil event for a recognized FtraceEvent")
+	}
+	if ev.Name != "sched_switch" || ev.CPU != 3 || ev.Timestamp != 1234 {
+		t.Errorf("traceEventFromPerfettoFtraceEvent = %+v, want name sched_switch, CPU 3, timestamp 1234", ev)
+	}
+	if ev.NumberProperties["next_pid"] != 42 || ev.TextProperties["next_comm"] != "next" {
+		t.Errorf("traceEventFromPerfettoFtraceEvent next fields = %+v/%+v, want next_pid=42, next_comm=next", ev.NumberProperties, ev.TextProperties)
+	}
+	if ev.NumberProperties["prev_pid"] != 7 || ev.TextProperties["prev_comm"] != "prev" {
+		t.Errorf("traceEventFromPerfettoFtraceEvent prev fields = %+v/%+v, want prev_pid=7, prev_comm=prev", ev.NumberProperties, ev.TextProperties)
+	}
+}
+
+func TestTraceEventFromPerfettoFtraceEventUnrecognizedIsNilNoError(t *testing.T) {
+	ev, err := traceEventFromPerfettoFtraceEvent(0, CPUID(0), &perfetto_ftrace.FtraceEvent{})
+	if err != nil {
+		t.Fatalf("traceEventFromPerfettoFtraceEvent returned error for an unrecognized FtraceEvent: %v", err)
+	}
+	if ev != nil {
+		t.Errorf("traceEventFromPerfettoFtraceEvent(unrecognized) = %+v, want nil", ev)
+	}
+}
@@ -0,0 +1,131 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package sched
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/google/schedviz/tracedata/trace"
+)
+
+// PIDRemapper deterministically substitutes a synthetic PID and command
+// string for a real one, so that a Collection can be shared externally
+// without revealing real thread identities.
+type PIDRemapper interface {
+	// RemapPID returns the synthetic PID to substitute for pid.
+	RemapPID(pid PID) PID
+	// RemapComm returns the synthetic command string to substitute for comm,
+	// as reported for pid.
+	RemapComm(pid PID, comm string) string
+}
+
+// pidCommField pairs the trace.Event NumberProperties key carrying a PID
+// with the TextProperties key (if any) carrying the command reported
+// alongside it.
+type pidCommField struct {
+	pidField, commField string
+}
+
+// remappablePIDFields lists every (pid, comm) field pair the default and
+// switch-only loaders read from a trace.Event.
+var remappablePIDFields = []pidCommField{
+	{"pid", "comm"},
+	{"next_pid", "next_comm"},
+	{"prev_pid", "prev_comm"},
+	{"child_pid", "child_comm"},
+}
+
+// WithPIDRemap wraps loaders so that, before any underlying loader function
+// runs, every pid, next_pid, prev_pid, comm, next_comm, and prev_comm field
+// present on a trace.Event is rewritten per remap. The result can be passed
+// anywhere a loader map is expected, including as DefaultEventLoaders() or
+// SwitchOnlyLoaders(), so that a Collection can be anonymized during
+// ingestion rather than requiring a separate redaction pass afterward.
+func WithPIDRemap(loaders map[string]func(*trace.Event, *ThreadTransitionSetBuilder) error, remap PIDRemapper) map[string]func(*trace.Event, *ThreadTransitionSetBuilder) error {
+	wrapped := make(map[string]func(*trace.Event, *ThreadTransitionSetBuilder) error, len(loaders))
+	for name, loader := range loaders {
+		loader := loader
+		wrapped[name] = func(ev *trace.Event, ttsb *ThreadTransitionSetBuilder) error {
+			return loader(remapEventPIDs(ev, remap), ttsb)
+		}
+	}
+	return wrapped
+}
+
+// remapEventPIDs returns a copy of ev with its PID and command fields
+// rewritten per remap, leaving ev itself untouched. WithPIDRemap may be
+// composed over the same underlying event stream as other loaders or
+// collections -- including an unredacted one -- so remapping must not mutate
+// the shared *trace.Event those other readers still hold.
+func remapEventPIDs(ev *trace.Event, remap PIDRemapper) *trace.Event {
+	out := *ev
+	out.NumberProperties = make(map[string]int64, len(ev.NumberProperties))
+	for k, v := range ev.NumberProperties {
+		out.NumberProperties[k] = v
+	}
+	out.TextProperties = make(map[string]string, len(ev.TextProperties))
+	for k, v := range ev.TextProperties {
+		out.TextProperties[k] = v
+	}
+	for _, field := range remappablePIDFields {
+		rawPID, ok := out.NumberProperties[field.pidField]
+		if !ok {
+			continue
+		}
+		pid := PID(rawPID)
+		if comm, ok := out.TextProperties[field.commField]; ok {
+			out.TextProperties[field.commField] = remap.RemapComm(pid, comm)
+		}
+		out.NumberProperties[field.pidField] = int64(remap.RemapPID(pid))
+	}
+	return &out
+}
+
+// hmacPIDRemapper is a PIDRemapper whose synthetic PIDs and command strings
+// are derived from HMAC-SHA256 over a caller-supplied key, so that a given
+// real PID, or a given command as reported for a given PID, always remaps to
+// the same synthetic value under a given key, while remappings produced under different keys cannot be
+// correlated with one another.
+type hmacPIDRemapper struct {
+	key []byte
+}
+
+// NewHMACPIDRemapper returns a PIDRemapper that derives synthetic PIDs and
+// command strings from HMAC-SHA256(key, ...).
+func NewHMACPIDRemapper(key []byte) PIDRemapper {
+	return &hmacPIDRemapper{key: key}
+}
+
+// RemapPID implements PIDRemapper.
+func (h *hmacPIDRemapper) RemapPID(pid PID) PID {
+	mac := hmac.New(sha256.New, h.key)
+	binary.Write(mac, binary.LittleEndian, int64(pid))
+	sum := mac.Sum(nil)
+	// Mask off the sign bit so the remapped PID is never negative.
+	return PID(binary.LittleEndian.Uint32(sum[:4]) & 0x7fffffff)
+}
+
+// RemapComm implements PIDRemapper.
+func (h *hmacPIDRemapper) RemapComm(pid PID, comm string) string {
+	mac := hmac.New(sha256.New, h.key)
+	binary.Write(mac, binary.LittleEndian, int64(pid))
+	mac.Write([]byte(comm))
+	return fmt.Sprintf("task-%x", mac.Sum(nil)[:4])
+}
@@ -0,0 +1,112 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package sched
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/schedviz/tracedata/trace"
+)
+
+// identityRemapper is a PIDRemapper whose RemapPID/RemapComm are
+// deterministic but distinguishable from their inputs, so tests can tell a
+// remapped field from an untouched one without depending on the real
+// HMAC-based hmacPIDRemapper.
+type identityRemapper struct{}
+
+func (identityRemapper) RemapPID(pid PID) PID { return pid + 1000 }
+func (identityRemapper) RemapComm(pid PID, comm string) string {
+	return fmt.Sprintf("remapped-%s", comm)
+}
+
+func TestRemapEventPIDsDoesNotMutateOriginal(t *testing.T) {
+	orig := &trace.Event{
+		Name: "sched_switch",
+		NumberProperties: map[string]int64{
+			"next_pid": 1,
+			"prev_pid": 2,
+		},
+		TextProperties: map[string]string{
+			"next_comm": "a",
+			"prev_comm": "b",
+		},
+	}
+	origNextPID := orig.NumberProperties["next_pid"]
+	origNextComm := orig.TextProperties["next_comm"]
+
+	remapped := remapEventPIDs(orig, identityRemapper{})
+
+	if orig.NumberProperties["next_pid"] != origNextPID {
+		t.Errorf("remapEventPIDs mutated orig.NumberProperties[next_pid]: got %d, want %d", orig.NumberProperties["next_pid"], origNextPID)
+	}
+	if orig.TextProperties["next_comm"] != origNextComm {
+		t.Errorf("remapEventPIDs mutated orig.TextProperties[next_comm]: got %q, want %q", orig.TextProperties["next_comm"], origNextComm)
+	}
+	if remapped == orig {
+		t.Fatalf("remapEventPIDs returned the same *trace.Event it was given, want a copy")
+	}
+	if remapped.NumberProperties["next_pid"] != 1001 {
+		t.Errorf("remapped next_pid = %d, want 1001", remapped.NumberProperties["next_pid"])
+	}
+	if remapped.NumberProperties["prev_pid"] != 1002 {
+		t.Errorf("remapped prev_pid = %d, want 1002", remapped.NumberProperties["prev_pid"])
+	}
+	if remapped.TextProperties["next_comm"] != "remapped-a" {
+		t.Errorf("remapped next_comm = %q, want %q", remapped.TextProperties["next_comm"], "remapped-a")
+	}
+}
+
+func TestRemapEventPIDsLeavesAbsentFieldsAlone(t *testing.T) {
+	orig := &trace.Event{
+		Name:             "sched_wakeup",
+		NumberProperties: map[string]int64{"pid": 5},
+		TextProperties:   map[string]string{"comm": "c"},
+	}
+	remapped := remapEventPIDs(orig, identityRemapper{})
+	if _, ok := remapped.NumberProperties["next_pid"]; ok {
+		t.Errorf("remapEventPIDs invented a next_pid field that wasn't present in orig")
+	}
+	if remapped.NumberProperties["pid"] != 1005 {
+		t.Errorf("remapped pid = %d, want 1005", remapped.NumberProperties["pid"])
+	}
+}
+
+func TestWithPIDRemapPreservesOriginalEventForOtherLoaders(t *testing.T) {
+	orig := &trace.Event{
+		Name:             "sched_wakeup",
+		NumberProperties: map[string]int64{"pid": 5},
+		TextProperties:   map[string]string{"comm": "c"},
+	}
+	var sawPID PID
+	loaders := map[string]func(*trace.Event, *ThreadTransitionSetBuilder) error{
+		"sched_wakeup": func(ev *trace.Event, ttsb *ThreadTransitionSetBuilder) error {
+			sawPID = PID(ev.NumberProperties["pid"])
+			return nil
+		},
+	}
+	wrapped := WithPIDRemap(loaders, identityRemapper{})
+	if err := wrapped["sched_wakeup"](orig, nil); err != nil {
+		t.Fatalf("wrapped loader returned error: %v", err)
+	}
+	if sawPID != 1005 {
+		t.Errorf("wrapped loader saw pid %d, want 1005", sawPID)
+	}
+	if orig.NumberProperties["pid"] != 5 {
+		t.Errorf("WithPIDRemap mutated the shared event's pid: got %d, want 5", orig.NumberProperties["pid"])
+	}
+}
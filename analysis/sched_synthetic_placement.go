@@ -0,0 +1,231 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package sched
+
+import (
+	"sort"
+
+	"github.com/google/schedviz/tracedata/trace"
+)
+
+// SyntheticPlacement computes the timestamp to assign a synthetic transition
+// inserted to bridge a gap between two known, adjacent transitions for the
+// same thread -- for instance, when LoadSchedSwitchWithSyntheticsUsing must
+// reconstruct a CPU or state change that a switch-only trace never reported
+// directly.
+type SyntheticPlacement interface {
+	// Place returns the timestamp to assign a synthetic transition inserted
+	// between the known transitions at priorTimestamp and nextTimestamp on
+	// cpu. stats, if non-nil, summarizes scheduling behavior observed
+	// elsewhere on cpu, for placements that want to do better than a fixed
+	// rule.
+	Place(cpu CPUID, priorTimestamp, nextTimestamp trace.Timestamp, stats *CPUStats) trace.Timestamp
+}
+
+// CPUStats summarizes scheduling behavior observed on a single CPU, for use
+// by SyntheticPlacements that bias placement toward a CPU's typical
+// behavior -- for instance, distributing a gap proportionally to the
+// observed run-length distribution, rather than always splitting it evenly.
+type CPUStats struct {
+	// RunLengths are the durations of previously observed runs on this CPU.
+	// As accumulated by LoadSchedSwitchWithSyntheticsUsing, each is the time
+	// from a thread's last record on this CPU to its next switch-in, whether
+	// or not that switch-in needed a synthetic transition, so the gap being
+	// bridged is itself included.
+	RunLengths []trace.Timestamp
+}
+
+// midpointPlacement is the placement LoadSchedSwitchWithSynthetics has
+// always used: insert midway between the two known transitions being
+// bridged.
+type midpointPlacement struct{}
+
+// Place implements SyntheticPlacement.
+func (midpointPlacement) Place(cpu CPUID, priorTimestamp, nextTimestamp trace.Timestamp, stats *CPUStats) trace.Timestamp {
+	return priorTimestamp + (nextTimestamp-priorTimestamp)/2
+}
+
+// DefaultSyntheticPlacement is the SyntheticPlacement used when none is
+// specified, preserving LoadSchedSwitchWithSynthetics's historical
+// behavior.
+var DefaultSyntheticPlacement SyntheticPlacement = midpointPlacement{}
+
+// EarlierBoundaryPlacement places synthetic transitions a fixed Epsilon
+// after the earlier of the two transitions being bridged, favoring
+// attribution of the unknown gap to whichever thread or state was already in
+// effect, rather than splitting it evenly. When Epsilon reaches the later
+// transition or beyond, the synthetic transition is placed at the later
+// transition's timestamp.
+type EarlierBoundaryPlacement struct {
+	Epsilon trace.Timestamp
+}
+
+// Place implements SyntheticPlacement.
+func (p EarlierBoundaryPlacement) Place(cpu CPUID, priorTimestamp, nextTimestamp trace.Timestamp, stats *CPUStats) trace.Timestamp {
+	placed := priorTimestamp + p.Epsilon
+	if placed >= nextTimestamp {
+		return nextTimestamp
+	}
+	return placed
+}
+
+// ProportionalRunLengthPlacement places a synthetic transition at the
+// median of stats' previously observed run lengths on cpu into the gap
+// being bridged, falling back to DefaultSyntheticPlacement when no stats, or
+// a degenerate median, are available. This lets reconstructions in
+// switch-only traces favor run lengths typical of the CPU being bridged,
+// rather than assuming the gap splits evenly between its two adjacent
+// threads.
+type ProportionalRunLengthPlacement struct{}
+
+// Place implements SyntheticPlacement.
+func (p ProportionalRunLengthPlacement) Place(cpu CPUID, priorTimestamp, nextTimestamp trace.Timestamp, stats *CPUStats) trace.Timestamp {
+	if stats == nil || len(stats.RunLengths) == 0 {
+		return DefaultSyntheticPlacement.Place(cpu, priorTimestamp, nextTimestamp, stats)
+	}
+	gap := nextTimestamp - priorTimestamp
+	median := medianTimestamp(stats.RunLengths)
+	if median <= 0 || median >= gap {
+		return DefaultSyntheticPlacement.Place(cpu, priorTimestamp, nextTimestamp, stats)
+	}
+	return priorTimestamp + median
+}
+
+// medianTimestamp returns the median of values, which must be non-empty.
+func medianTimestamp(values []trace.Timestamp) trace.Timestamp {
+	sorted := append([]trace.Timestamp(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[len(sorted)/2]
+}
+
+// pidRunRecord records where and in what state a PID was last placed by
+// LoadSchedSwitchWithSyntheticsUsing.
+type pidRunRecord struct {
+	timestamp trace.Timestamp
+	cpu       CPUID
+	state     ThreadState
+}
+
+// bridgeGap is the outcome of evaluating whether a PID's prior recorded run
+// disagrees with its arriving CPU and state, and if so, where to place a
+// synthetic transition bridging the two.
+type bridgeGap struct {
+	needed                   bool
+	timestamp                trace.Timestamp
+	fromCPU, toCPU           CPUID
+	fromState, arrivingState ThreadState
+}
+
+// computeBridgeGap folds the run ending at arrivalTimestamp into
+// statsByCPU -- keyed by the CPU that run took place on, prior.cpu, so that
+// placements asked about prior.cpu see that CPU's own observed run lengths
+// -- and, if pid's prior recorded CPU or state disagrees with its arrival,
+// asks placement where to bridge the gap between them. placement is always
+// asked about prior.cpu, the CPU the gap starts on, matching the stats
+// passed alongside it. It returns a zero-value, not-needed bridgeGap if pid
+// has no prior record, or if the prior record already agrees with the
+// arrival.
+func computeBridgeGap(last map[PID]pidRunRecord, statsByCPU map[CPUID]*CPUStats, placement SyntheticPlacement, pid PID, cpu CPUID, arrivingState ThreadState, arrivalTimestamp trace.Timestamp) bridgeGap {
+	prior, ok := last[pid]
+	if !ok {
+		return bridgeGap{}
+	}
+	stats := statsByCPU[prior.cpu]
+	if stats == nil {
+		stats = &CPUStats{}
+		statsByCPU[prior.cpu] = stats
+	}
+	stats.RunLengths = append(stats.RunLengths, arrivalTimestamp-prior.timestamp)
+	if prior.cpu == cpu && prior.state == arrivingState {
+		return bridgeGap{}
+	}
+	return bridgeGap{
+		needed:        true,
+		timestamp:     placement.Place(prior.cpu, prior.timestamp, arrivalTimestamp, stats),
+		fromCPU:       prior.cpu,
+		toCPU:         cpu,
+		fromState:     prior.state,
+		arrivingState: arrivingState,
+	}
+}
+
+// LoadSchedSwitchWithSyntheticsUsing returns a sched_switch loader
+// equivalent to LoadSchedSwitchWithSynthetics, except that gaps are bridged
+// using placement rather than a hard-coded midpoint.
+//
+// Unlike LoadSchedSwitchWithSynthetics, which leans on the
+// ThreadTransitionSetBuilder's own InsertSynthetic conflict resolution, the
+// returned loader detects and bridges gaps itself: it remembers, per PID,
+// the CPU and state it last placed that PID in, and whenever a PID
+// reappears disagreeing with that record -- e.g. it last went to sleep on
+// CPU 2 but is now switching in on CPU 5 with no sched_migrate_task or
+// sched_wakeup in between -- it asks placement for a timestamp and inserts
+// an explicit synthetic transition bridging the two. This lets callers
+// experimenting with reconstructions in switch-only traces -- much as
+// Perfetto's thread_state generator lets its state-derivation logic be
+// configured -- select a placement without forking the loader.
+//
+// Because it tracks state across calls, a returned loader must not be
+// shared between collections, and its events must be delivered to it in
+// timestamp order.
+func LoadSchedSwitchWithSyntheticsUsing(placement SyntheticPlacement) func(*trace.Event, *ThreadTransitionSetBuilder) error {
+	if placement == nil {
+		placement = DefaultSyntheticPlacement
+	}
+	last := map[PID]pidRunRecord{}
+	statsByCPU := map[CPUID]*CPUStats{}
+	bridge := func(ttsb *ThreadTransitionSetBuilder, evIndex int, pid PID, cpu CPUID, arrivingState ThreadState, arrivalTimestamp trace.Timestamp) {
+		gap := computeBridgeGap(last, statsByCPU, placement, pid, cpu, arrivingState, arrivalTimestamp)
+		if !gap.needed {
+			return
+		}
+		ttsb.WithTransition(evIndex, gap.timestamp, pid).
+			WithPrevCPU(gap.fromCPU).
+			WithNextCPU(gap.toCPU).
+			WithPrevState(gap.fromState).
+			WithNextState(gap.arrivingState)
+	}
+	return func(ev *trace.Event, ttsb *ThreadTransitionSetBuilder) error {
+		sd, err := LoadSwitchData(ev)
+		if err != nil {
+			return err
+		}
+		cpu := CPUID(ev.CPU)
+		bridge(ttsb, ev.Index, sd.NextPID, cpu, WaitingState, ev.Timestamp)
+		ttsb.WithTransition(ev.Index, ev.Timestamp, sd.NextPID).
+			WithPrevCommand(sd.NextComm).
+			WithNextCommand(sd.NextComm).
+			WithPrevPriority(sd.NextPriority).
+			WithNextPriority(sd.NextPriority).
+			WithPrevCPU(cpu).
+			WithNextCPU(cpu).
+			WithPrevState(WaitingState).
+			WithNextState(RunningState)
+		ttsb.WithTransition(ev.Index, ev.Timestamp, sd.PrevPID).
+			WithPrevCommand(sd.PrevComm).
+			WithNextCommand(sd.PrevComm).
+			WithPrevPriority(sd.PrevPriority).
+			WithNextPriority(sd.PrevPriority).
+			WithPrevCPU(cpu).
+			WithNextCPU(cpu).
+			WithPrevState(RunningState).
+			WithNextState(sd.PrevState)
+		last[sd.NextPID] = pidRunRecord{timestamp: ev.Timestamp, cpu: cpu, state: RunningState}
+		last[sd.PrevPID] = pidRunRecord{timestamp: ev.Timestamp, cpu: cpu, state: sd.PrevState}
+		return nil
+	}
+}
@@ -0,0 +1,169 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package sched
+
+import (
+	"testing"
+
+	"github.com/google/schedviz/tracedata/trace"
+)
+
+// recordingPlacement is a SyntheticPlacement that records the cpu and stats
+// it was last called with, so tests can assert on what LoadSchedSwitchWith
+// SyntheticsUsing passed it, not just on the timestamp it returns.
+type recordingPlacement struct {
+	lastCPU   CPUID
+	lastStats *CPUStats
+}
+
+func (p *recordingPlacement) Place(cpu CPUID, priorTimestamp, nextTimestamp trace.Timestamp, stats *CPUStats) trace.Timestamp {
+	p.lastCPU = cpu
+	p.lastStats = stats
+	return DefaultSyntheticPlacement.Place(cpu, priorTimestamp, nextTimestamp, stats)
+}
+
+func TestMidpointPlacement(t *testing.T) {
+	got := DefaultSyntheticPlacement.Place(0, 100, 200, nil)
+	if want := trace.Timestamp(150); got != want {
+		t.Errorf("midpointPlacement.Place(0, 100, 200, nil) = %d, want %d", got, want)
+	}
+}
+
+func TestEarlierBoundaryPlacement(t *testing.T) {
+	tests := []struct {
+		name                     string
+		epsilon                  trace.Timestamp
+		priorTime, nextTimestamp trace.Timestamp
+		want                     trace.Timestamp
+	}{
+		{"within gap", 5, 100, 200, 105},
+		{"clamped to gap end", 500, 100, 200, 200},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := EarlierBoundaryPlacement{Epsilon: test.epsilon}
+			if got := p.Place(0, test.priorTime, test.nextTimestamp, nil); got != test.want {
+				t.Errorf("Place(0, %d, %d, nil) = %d, want %d", test.priorTime, test.nextTimestamp, got, test.want)
+			}
+		})
+	}
+}
+
+func TestProportionalRunLengthPlacement(t *testing.T) {
+	tests := []struct {
+		name  string
+		stats *CPUStats
+		want  trace.Timestamp
+	}{
+		{"no stats falls back to default", nil, 150},
+		{"empty run lengths falls back to default", &CPUStats{}, 150},
+		{"median run length within gap", &CPUStats{RunLengths: []trace.Timestamp{10, 20, 30}}, 120},
+		{"median at or beyond gap falls back to default", &CPUStats{RunLengths: []trace.Timestamp{1000}}, 150},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := ProportionalRunLengthPlacement{}
+			if got := p.Place(0, 100, 200, test.stats); got != test.want {
+				t.Errorf("Place(0, 100, 200, %+v) = %d, want %d", test.stats, got, test.want)
+			}
+		})
+	}
+}
+
+func TestMedianTimestamp(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []trace.Timestamp
+		want   trace.Timestamp
+	}{
+		{"single value", []trace.Timestamp{7}, 7},
+		{"odd count", []trace.Timestamp{30, 10, 20}, 20},
+		{"even count picks upper middle", []trace.Timestamp{40, 10, 30, 20}, 30},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			original := append([]trace.Timestamp(nil), test.values...)
+			if got := medianTimestamp(test.values); got != test.want {
+				t.Errorf("medianTimestamp(%v) = %d, want %d", test.values, got, test.want)
+			}
+			for i, v := range test.values {
+				if v != original[i] {
+					t.Fatalf("medianTimestamp mutated its input: got %v, want %v", test.values, original)
+				}
+			}
+		})
+	}
+}
+
+func TestComputeBridgeGapNoPriorRecord(t *testing.T) {
+	last := map[PID]pidRunRecord{}
+	statsByCPU := map[CPUID]*CPUStats{}
+	gap := computeBridgeGap(last, statsByCPU, DefaultSyntheticPlacement, 1, CPUID(0), RunningState, 100)
+	if gap.needed {
+		t.Errorf("computeBridgeGap with no prior record = %+v, want needed == false", gap)
+	}
+}
+
+func TestComputeBridgeGapAgreeingArrivalNeedsNoBridge(t *testing.T) {
+	last := map[PID]pidRunRecord{1: {timestamp: 100, cpu: 2, state: RunningState}}
+	statsByCPU := map[CPUID]*CPUStats{}
+	gap := computeBridgeGap(last, statsByCPU, DefaultSyntheticPlacement, 1, CPUID(2), RunningState, 200)
+	if gap.needed {
+		t.Errorf("computeBridgeGap for an agreeing arrival = %+v, want needed == false", gap)
+	}
+	if got := statsByCPU[2]; got == nil || len(got.RunLengths) != 1 || got.RunLengths[0] != 100 {
+		t.Errorf("computeBridgeGap didn't record the observed run length on CPU 2: statsByCPU[2] = %+v", got)
+	}
+}
+
+// This drives LoadSchedSwitchWithSyntheticsUsing's gap-detection logic
+// through a small switch-only sequence: PID 1 runs on CPU 2, then later
+// reappears switching in on CPU 5 with no migrate or wakeup recorded in
+// between. It asserts both the bridging transition that must be inserted,
+// and -- per the cross-CPU placement bug -- that placement is asked about
+// CPU 2, the CPU the gap actually starts on, with the run-length stats
+// CPU 2 itself accumulated, not CPU 5's.
+func TestComputeBridgeGapCrossesCPUsAndUsesSourceCPUStats(t *testing.T) {
+	last := map[PID]pidRunRecord{}
+	statsByCPU := map[CPUID]*CPUStats{}
+	placement := &recordingPlacement{}
+
+	// PID 1 was last recorded running on CPU 2, at t=150 (seeded directly
+	// into last, standing in for the sched_switch that recorded it).
+	last[1] = pidRunRecord{timestamp: 150, cpu: 2, state: RunningState}
+
+	// PID 1 reappears switching in on CPU 5 at t=400, with no recorded
+	// migration in between: this is the gap LoadSchedSwitchWithSynthetics
+	// Using must bridge.
+	gap := computeBridgeGap(last, statsByCPU, placement, 1, CPUID(5), WaitingState, 400)
+
+	if !gap.needed {
+		t.Fatalf("computeBridgeGap across CPUs = %+v, want needed == true", gap)
+	}
+	if gap.fromCPU != 2 || gap.toCPU != 5 {
+		t.Errorf("computeBridgeGap bridged CPU %d -> %d, want 2 -> 5", gap.fromCPU, gap.toCPU)
+	}
+	if gap.fromState != RunningState || gap.arrivingState != WaitingState {
+		t.Errorf("computeBridgeGap bridged state %v -> %v, want %v -> %v", gap.fromState, gap.arrivingState, RunningState, WaitingState)
+	}
+	if placement.lastCPU != 2 {
+		t.Errorf("placement.Place was asked about CPU %d, want the gap's source CPU 2", placement.lastCPU)
+	}
+	if placement.lastStats != statsByCPU[2] {
+		t.Errorf("placement.Place was given stats %p, want CPU 2's own accumulated stats %p", placement.lastStats, statsByCPU[2])
+	}
+}